@@ -0,0 +1,131 @@
+/**
+ * Copyright (C) 2018 Yahoo Japan Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ * <p/>
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * <p/>
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ClientConfig holds the flags needed to build the *http.Client used to
+// talk to every Presto coordinator.
+type ClientConfig struct {
+	User               string
+	PasswordFile       string
+	BearerTokenFile    string
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+	Timeout            time.Duration
+}
+
+// prestoRoundTripper attaches the configured Presto user header and
+// credentials to every outgoing request before handing it to the
+// underlying transport.
+type prestoRoundTripper struct {
+	next        http.RoundTripper
+	user        string
+	password    string
+	bearerToken string
+}
+
+func (rt *prestoRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if rt.user != "" {
+		req.Header.Set("X-Presto-User", rt.user)
+	}
+	if rt.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+rt.bearerToken)
+	} else if rt.password != "" {
+		req.SetBasicAuth(rt.user, rt.password)
+	}
+	return rt.next.RoundTrip(req)
+}
+
+// newHTTPClient builds the *http.Client shared by every cluster scrape,
+// configuring TLS (mTLS and custom CA bundles) and auth (X-Presto-User,
+// HTTP basic auth, bearer tokens) once at startup.
+func newHTTPClient(cfg ClientConfig) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+
+	if cfg.CAFile != "" {
+		caCert, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return nil, fmt.Errorf("presto.cert-file and presto.key-file must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	var password, bearerToken string
+	if cfg.PasswordFile != "" {
+		b, err := ioutil.ReadFile(cfg.PasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading password file: %w", err)
+		}
+		password = strings.TrimSpace(string(b))
+	}
+	if cfg.BearerTokenFile != "" {
+		b, err := ioutil.ReadFile(cfg.BearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading bearer token file: %w", err)
+		}
+		bearerToken = strings.TrimSpace(string(b))
+	}
+
+	baseTransport := http.DefaultTransport.(*http.Transport).Clone()
+	baseTransport.TLSClientConfig = tlsConfig
+
+	var transport http.RoundTripper = baseTransport
+	transport = promhttp.InstrumentRoundTripperDuration(prestoRequestDuration, transport)
+	transport = promhttp.InstrumentRoundTripperCounter(prestoRequestsTotal, transport)
+
+	return &http.Client{
+		Timeout: cfg.Timeout,
+		Transport: &prestoRoundTripper{
+			next:        transport,
+			user:        cfg.User,
+			password:    password,
+			bearerToken: bearerToken,
+		},
+	}, nil
+}
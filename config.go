@@ -0,0 +1,85 @@
+/**
+ * Copyright (C) 2018 Yahoo Japan Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ * <p/>
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * <p/>
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ClusterConfig identifies a single Presto coordinator to scrape and the
+// cluster label it should be reported under.
+type ClusterConfig struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+}
+
+// Config is the top-level structure of the YAML file accepted by
+// --config.file, listing every cluster the exporter should federate.
+type Config struct {
+	Clusters []ClusterConfig `yaml:"clusters"`
+}
+
+// loadConfigFile reads and validates a YAML cluster config file.
+func loadConfigFile(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(cfg.Clusters) == 0 {
+		return nil, fmt.Errorf("%s defines no clusters", path)
+	}
+	for i, c := range cfg.Clusters {
+		if c.URL == "" {
+			return nil, fmt.Errorf("%s: cluster %d is missing a url", path, i)
+		}
+		if c.Name == "" {
+			cfg.Clusters[i].Name = clusterNameFromURL(c.URL)
+		}
+	}
+	return &cfg, nil
+}
+
+// clustersFromURLs builds a ClusterConfig per --web.url flag occurrence,
+// deriving the cluster label from the coordinator host.
+func clustersFromURLs(urls []string) []ClusterConfig {
+	clusters := make([]ClusterConfig, 0, len(urls))
+	for _, u := range urls {
+		clusters = append(clusters, ClusterConfig{
+			Name: clusterNameFromURL(u),
+			URL:  u,
+		})
+	}
+	return clusters
+}
+
+// clusterNameFromURL derives a default cluster label from a coordinator
+// URL's host, e.g. "http://presto-prod:8080" -> "presto-prod:8080".
+func clusterNameFromURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	return u.Host
+}
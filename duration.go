@@ -0,0 +1,75 @@
+/**
+ * Copyright (C) 2018 Yahoo Japan Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ * <p/>
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * <p/>
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"regexp"
+	"strconv"
+)
+
+var prestoValueUnitRe = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)([a-zA-Z]+)$`)
+
+// prestoDurationUnits maps the unit suffixes used by io.airlift.units.Duration
+// (as rendered by Presto's query stats) to seconds.
+var prestoDurationUnits = map[string]float64{
+	"ns": 1e-9,
+	"us": 1e-6,
+	"ms": 1e-3,
+	"s":  1,
+	"m":  60,
+	"h":  3600,
+	"d":  86400,
+}
+
+// prestoDataSizeUnits maps the unit suffixes used by io.airlift.units.DataSize
+// (as rendered by Presto's query stats) to bytes.
+var prestoDataSizeUnits = map[string]float64{
+	"B":  1,
+	"kB": 1 << 10,
+	"MB": 1 << 20,
+	"GB": 1 << 30,
+	"TB": 1 << 40,
+	"PB": 1 << 50,
+}
+
+// parsePrestoDuration parses a Presto duration string such as "123.00ms" or
+// "4.20s" into seconds. It returns 0 if the string cannot be parsed.
+func parsePrestoDuration(s string) float64 {
+	return parsePrestoValue(s, prestoDurationUnits)
+}
+
+// parsePrestoDataSize parses a Presto data size string such as "10.50MB"
+// into bytes. It returns 0 if the string cannot be parsed.
+func parsePrestoDataSize(s string) float64 {
+	return parsePrestoValue(s, prestoDataSizeUnits)
+}
+
+func parsePrestoValue(s string, units map[string]float64) float64 {
+	m := prestoValueUnitRe.FindStringSubmatch(s)
+	if m == nil {
+		return 0
+	}
+	value, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0
+	}
+	factor, ok := units[m[2]]
+	if !ok {
+		return 0
+	}
+	return value * factor
+}
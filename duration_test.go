@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func approxEqual(a, b float64) bool {
+	if a == b {
+		return true
+	}
+	return math.Abs(a-b) <= 1e-9*math.Max(math.Abs(a), math.Abs(b))
+}
+
+func TestParsePrestoDuration(t *testing.T) {
+	cases := []struct {
+		in   string
+		want float64
+	}{
+		{"123.00ms", 0.123},
+		{"4.20s", 4.2},
+		{"1.00m", 60},
+		{"2.00h", 7200},
+		{"1.00d", 86400},
+		{"500ns", 500e-9},
+		{"10us", 10e-6},
+		{"", 0},
+		{"garbage", 0},
+		{"5.00xyz", 0},
+	}
+	for _, c := range cases {
+		if got := parsePrestoDuration(c.in); !approxEqual(got, c.want) {
+			t.Errorf("parsePrestoDuration(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestParsePrestoDataSize(t *testing.T) {
+	cases := []struct {
+		in   string
+		want float64
+	}{
+		{"1.00B", 1},
+		{"1.00kB", 1024},
+		{"10.50MB", 10.5 * (1 << 20)},
+		{"2.00GB", 2 * (1 << 30)},
+		{"1.00TB", 1 << 40},
+		{"1.00PB", 1 << 50},
+		{"", 0},
+		{"garbage", 0},
+		{"5.00XB", 0},
+	}
+	for _, c := range cases {
+		if got := parsePrestoDataSize(c.in); got != c.want {
+			t.Errorf("parsePrestoDataSize(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
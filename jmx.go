@@ -0,0 +1,310 @@
+/**
+ * Copyright (C) 2018 Yahoo Japan Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ * <p/>
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * <p/>
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+)
+
+// JMXSelector picks one JMX MBean attribute to scrape from every worker and
+// the Prometheus metric it should be reported as.
+type JMXSelector struct {
+	MBean     string  `yaml:"mbean"`
+	Attribute string  `yaml:"attribute"`
+	Metric    string  `yaml:"metric"`
+	Help      string  `yaml:"help"`
+	Type      string  `yaml:"type"`  // "gauge" (default) or "counter"
+	Scale     float64 `yaml:"scale"` // multiplied onto the raw attribute value; defaults to 1
+}
+
+// defaultJMXSelectors covers the JVM heap and GC mbeans every worker
+// exposes. --presto.jmx.mbeans-file adds to this set.
+var defaultJMXSelectors = []JMXSelector{
+	{
+		MBean:     "java.lang:type=Memory",
+		Attribute: "HeapMemoryUsage.used",
+		Metric:    "presto_worker_jvm_heap_used_bytes",
+		Help:      "JVM heap memory used, in bytes.",
+		Type:      "gauge",
+	},
+	{
+		MBean:     "java.lang:type=Memory",
+		Attribute: "NonHeapMemoryUsage.used",
+		Metric:    "presto_worker_jvm_non_heap_used_bytes",
+		Help:      "JVM non-heap memory used, in bytes.",
+		Type:      "gauge",
+	},
+	{
+		MBean:     "presto.memory:name=ClusterMemoryPool",
+		Attribute: "ReservedBytes",
+		Metric:    "presto_worker_memory_pool_reserved_bytes",
+		Help:      "Reserved bytes in the cluster memory pool.",
+		Type:      "gauge",
+	},
+	{
+		MBean:     "presto.execution:name=TaskManager",
+		Attribute: "RunningSplits",
+		Metric:    "presto_worker_running_splits",
+		Help:      "Splits currently running on this worker.",
+		Type:      "gauge",
+	},
+	{
+		MBean:     "java.lang:type=GarbageCollector,name=G1 Young Generation",
+		Attribute: "CollectionCount",
+		Metric:    "presto_worker_gc_collection_count_total",
+		Help:      "Total garbage collections performed by the G1 Young Generation collector.",
+		Type:      "counter",
+	},
+	{
+		MBean:     "java.lang:type=GarbageCollector,name=G1 Young Generation",
+		Attribute: "CollectionTime",
+		Metric:    "presto_worker_gc_collection_time_seconds_total",
+		Help:      "Total time spent in garbage collection by the G1 Young Generation collector.",
+		Type:      "counter",
+		Scale:     0.001, // CollectionTime is reported in milliseconds
+	},
+}
+
+// loadJMXSelectors reads a YAML file of extra JMX selectors, as pointed to
+// by --presto.jmx.mbeans-file.
+func loadJMXSelectors(path string) ([]JMXSelector, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var selectors []JMXSelector
+	if err := yaml.Unmarshal(data, &selectors); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for i, s := range selectors {
+		if s.MBean == "" || s.Attribute == "" || s.Metric == "" {
+			return nil, fmt.Errorf("%s: selector %d must set mbean, attribute and metric", path, i)
+		}
+		if !model.IsValidMetricName(model.LabelValue(s.Metric)) {
+			return nil, fmt.Errorf("%s: selector %d has an invalid metric name %q", path, i, s.Metric)
+		}
+	}
+	return selectors, nil
+}
+
+// Node is a single entry of the cluster's /v1/node response.
+type Node struct {
+	NodeID string `json:"nodeId"`
+	URI    string `json:"uri"`
+}
+
+type jmxMBeanResponse struct {
+	ObjectName string `json:"objectName"`
+	Attributes []struct {
+		Name  string      `json:"name"`
+		Value interface{} `json:"value"`
+	} `json:"attributes"`
+}
+
+// JMXExporter scrapes per-worker JMX MBeans (java.lang:type=Memory,
+// presto.execution:name=TaskManager, GC collectors, etc.) across every
+// federated cluster, alongside the cluster/info/query Exporter collector.
+type JMXExporter struct {
+	clusters    []ClusterConfig
+	client      *http.Client
+	maxParallel int
+	selectors   []JMXSelector
+	descs       []*prometheus.Desc // one per selector, built by buildJMXDescs
+}
+
+// buildJMXDescs builds one Desc per selector, in the same order, so
+// collectWorker can reuse them on every scrape instead of rebuilding a Desc
+// from scratch for every worker.
+func buildJMXDescs(selectors []JMXSelector) []*prometheus.Desc {
+	descs := make([]*prometheus.Desc, len(selectors))
+	for i, s := range selectors {
+		descs[i] = prometheus.NewDesc(s.Metric, s.Help, []string{"cluster", "node_id", "worker_host"}, nil)
+	}
+	return descs
+}
+
+// Describe implements the prometheus.Collector interface. JMXExporter's
+// metric set is driven by --presto.jmx.mbeans-file, so it is registered as
+// an unchecked collector and describes nothing up front.
+func (e *JMXExporter) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect implements the prometheus.Collector interface.
+func (e *JMXExporter) Collect(ch chan<- prometheus.Metric) {
+	for _, cluster := range e.clusters {
+		nodes, err := e.fetchNodes(cluster)
+		if err != nil {
+			log.Printf("error listing workers for cluster %s: %s", cluster.Name, err)
+			scrapeErrorsTotal.WithLabelValues("node").Inc()
+			continue
+		}
+
+		maxParallel := e.maxParallel
+		if maxParallel < 1 {
+			maxParallel = 1
+		}
+		sem := make(chan struct{}, maxParallel)
+		var wg sync.WaitGroup
+		for _, node := range nodes {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(n Node) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				e.collectWorker(cluster, n, ch)
+			}(node)
+		}
+		wg.Wait()
+	}
+}
+
+func (e *JMXExporter) fetchNodes(cluster ClusterConfig) ([]Node, error) {
+	resp, err := e.client.Get(cluster.URL + "/v1/node")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected status %s from /v1/node", resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []Node
+	if err := json.Unmarshal(body, &nodes); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// collectWorker fetches every configured JMX selector from a single
+// worker's /v1/jmx/mbean/<name> endpoint and emits it as a metric labeled
+// with the cluster, node_id and worker_host.
+func (e *JMXExporter) collectWorker(cluster ClusterConfig, node Node, ch chan<- prometheus.Metric) {
+	workerHost := hostFromURL(node.URI)
+
+	for i, selector := range e.selectors {
+		mbean, err := e.fetchMBean(node.URI, selector.MBean)
+		if err != nil {
+			log.Printf("error scraping mbean %s on worker %s: %s", selector.MBean, workerHost, err)
+			scrapeErrorsTotal.WithLabelValues("jmx").Inc()
+			continue
+		}
+
+		value, ok := extractJMXAttribute(mbean, selector.Attribute)
+		if !ok {
+			log.Printf("attribute %s not found on mbean %s (worker %s)", selector.Attribute, selector.MBean, workerHost)
+			continue
+		}
+
+		scale := selector.Scale
+		if scale == 0 {
+			scale = 1
+		}
+
+		valueType := prometheus.GaugeValue
+		if selector.Type == "counter" {
+			valueType = prometheus.CounterValue
+		}
+
+		metric, err := prometheus.NewConstMetric(e.descs[i], valueType, value*scale, cluster.Name, node.NodeID, workerHost)
+		if err != nil {
+			log.Printf("error building metric %s for worker %s: %s", selector.Metric, workerHost, err)
+			continue
+		}
+		ch <- metric
+	}
+}
+
+func (e *JMXExporter) fetchMBean(workerURI, mbean string) (*jmxMBeanResponse, error) {
+	resp, err := e.client.Get(workerURI + "/v1/jmx/mbean/" + url.PathEscape(mbean))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var m jmxMBeanResponse
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// extractJMXAttribute resolves a dotted attribute path (e.g.
+// "HeapMemoryUsage.used") against an mbean's attributes, descending into
+// composite (nested object) values as needed.
+func extractJMXAttribute(mbean *jmxMBeanResponse, path string) (float64, bool) {
+	parts := strings.Split(path, ".")
+
+	var value interface{}
+	found := false
+	for _, attr := range mbean.Attributes {
+		if attr.Name == parts[0] {
+			value = attr.Value
+			found = true
+			break
+		}
+	}
+	if !found {
+		return 0, false
+	}
+
+	for _, key := range parts[1:] {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return 0, false
+		}
+		value, ok = obj[key]
+		if !ok {
+			return 0, false
+		}
+	}
+
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// hostFromURL extracts the host:port portion of a worker's URI for use as
+// the worker_host label.
+func hostFromURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	return u.Host
+}
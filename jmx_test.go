@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractJMXAttribute(t *testing.T) {
+	mbean := &jmxMBeanResponse{
+		ObjectName: "java.lang:type=Memory",
+		Attributes: []struct {
+			Name  string      `json:"name"`
+			Value interface{} `json:"value"`
+		}{
+			{Name: "HeapMemoryUsage", Value: map[string]interface{}{"used": 123.0, "max": 456.0}},
+			{Name: "ObjectPendingFinalizationCount", Value: 0.0},
+		},
+	}
+
+	cases := []struct {
+		path      string
+		wantValue float64
+		wantOK    bool
+	}{
+		{"HeapMemoryUsage.used", 123.0, true},
+		{"HeapMemoryUsage.max", 456.0, true},
+		{"ObjectPendingFinalizationCount", 0.0, true},
+		{"HeapMemoryUsage.missing", 0, false},
+		{"HeapMemoryUsage.used.tooDeep", 0, false},
+		{"NoSuchAttribute", 0, false},
+	}
+	for _, c := range cases {
+		got, ok := extractJMXAttribute(mbean, c.path)
+		if ok != c.wantOK || (ok && got != c.wantValue) {
+			t.Errorf("extractJMXAttribute(%q) = (%v, %v), want (%v, %v)", c.path, got, ok, c.wantValue, c.wantOK)
+		}
+	}
+}
+
+func TestLoadJMXSelectorsRejectsInvalidMetricName(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "selectors.yaml")
+	yaml := `
+- mbean: "java.lang:type=Memory"
+  attribute: "HeapMemoryUsage.used"
+  metric: "not a valid metric name"
+  help: "bad"
+  type: "gauge"
+`
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadJMXSelectors(path); err == nil {
+		t.Error("loadJMXSelectors should reject an invalid metric name, got nil error")
+	}
+}
+
+func TestLoadJMXSelectorsAcceptsValidMetricName(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "selectors.yaml")
+	yaml := `
+- mbean: "java.lang:type=Memory"
+  attribute: "HeapMemoryUsage.used"
+  metric: "presto_worker_custom_metric"
+  help: "ok"
+  type: "gauge"
+`
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	selectors, err := loadJMXSelectors(path)
+	if err != nil {
+		t.Fatalf("loadJMXSelectors returned error: %s", err)
+	}
+	if len(selectors) != 1 || selectors[0].Metric != "presto_worker_custom_metric" {
+		t.Errorf("loadJMXSelectors returned %+v", selectors)
+	}
+}
+
+func TestBuildJMXDescsOnePerSelector(t *testing.T) {
+	descs := buildJMXDescs(defaultJMXSelectors)
+	if len(descs) != len(defaultJMXSelectors) {
+		t.Errorf("len(descs) = %d, want %d", len(descs), len(defaultJMXSelectors))
+	}
+	for i, d := range descs {
+		if d == nil {
+			t.Errorf("descs[%d] is nil", i)
+		}
+	}
+}
+
+func TestHostFromURL(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{"http://worker1:8080", "worker1:8080"},
+		{"https://worker2.example.com:8443/v1/node", "worker2.example.com:8443"},
+		{"not a url", "not a url"},
+	}
+	for _, c := range cases {
+		if got := hostFromURL(c.in); got != c.want {
+			t.Errorf("hostFromURL(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
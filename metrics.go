@@ -0,0 +1,85 @@
+/**
+ * Copyright (C) 2018 Yahoo Japan Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ * <p/>
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * <p/>
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// exporterNamespace covers the exporter's self-observability metrics, as
+// opposed to namespace (presto_exporter.go) which covers the scraped Presto
+// clusters.
+const exporterNamespace = "presto_exporter"
+
+var (
+	// exporterHTTPRequestsTotal and exporterHTTPRequestDuration cover
+	// inbound requests to the exporter's own /metrics handler, via
+	// promhttp.InstrumentHandler*.
+	exporterHTTPRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: exporterNamespace,
+		Name:      "http_requests_total",
+		Help:      "Total HTTP requests served by the exporter's own /metrics handler, by status code and method.",
+	}, []string{"code", "method"})
+
+	exporterHTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: exporterNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of HTTP requests served by the exporter's own /metrics handler.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"code", "method"})
+
+	// prestoRequestsTotal and prestoRequestDuration cover outbound requests
+	// made by the exporter to Presto coordinators, via
+	// promhttp.InstrumentRoundTripper*. Kept separate from the inbound
+	// metrics above so "the /metrics handler is slow" and "Presto is slow
+	// to respond" don't land in the same series.
+	prestoRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: exporterNamespace,
+		Name:      "presto_requests_total",
+		Help:      "Total HTTP requests made by the exporter to Presto coordinators, by status code and method.",
+	}, []string{"code", "method"})
+
+	prestoRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: exporterNamespace,
+		Name:      "presto_request_duration_seconds",
+		Help:      "Duration of HTTP requests made by the exporter to Presto coordinators.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"code", "method"})
+
+	scrapeErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: exporterNamespace,
+		Name:      "scrape_errors_total",
+		Help:      "Total errors encountered while scraping a Presto coordinator, by endpoint.",
+	}, []string{"endpoint"})
+
+	lastScrapeDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: exporterNamespace,
+		Name:      "last_scrape_duration_seconds",
+		Help:      "Duration of the most recent scrape of a Presto cluster.",
+	}, []string{"cluster"})
+)
+
+// registerExporterMetrics registers the exporter's self-observability
+// metrics, separately from the per-cluster Exporter collector.
+func registerExporterMetrics() {
+	prometheus.MustRegister(
+		exporterHTTPRequestsTotal,
+		exporterHTTPRequestDuration,
+		prestoRequestsTotal,
+		prestoRequestDuration,
+		scrapeErrorsTotal,
+		lastScrapeDuration,
+	)
+}
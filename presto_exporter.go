@@ -18,15 +18,18 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"github.com/prometheus/common/log"
 	"github.com/prometheus/common/version"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
@@ -37,8 +40,14 @@ const (
 
 var hostname, _ = os.Hostname()
 
+// Exporter scrapes one or more Presto coordinators and reports their
+// metrics under a per-cluster "cluster" label, so a single exporter
+// process can federate an entire fleet.
 type Exporter struct {
-	uri string
+	clusters    []ClusterConfig
+	client      *http.Client
+	maxParallel int
+	queryLog    *QueryLog
 }
 
 type ClusterExporter struct {
@@ -64,11 +73,16 @@ type InfoExporter struct {
 }
 
 type Query struct {
-	QueryId    string `json:"queryId"`
-	State      string `json:"state"`
-	Scheduled  bool   `json:"scheduled"`
-	Query      string `json:"query"`
-	QueryStats struct {
+	QueryId   string `json:"queryId"`
+	State     string `json:"state"`
+	Scheduled bool   `json:"scheduled"`
+	Query     string `json:"query"`
+	Session   struct {
+		User   string `json:"user"`
+		Source string `json:"source"`
+	} `json:"session"`
+	ResourceGroupId []string `json:"resourceGroupId"`
+	QueryStats      struct {
 		QueuedTime                string  `json:"queuedTime"`
 		ElapsedTime               string  `json:"elapsedTime"`
 		ExecutionTime             string  `json:"executionTime"`
@@ -86,66 +100,87 @@ type QueryExporter struct {
 }
 
 var (
+	prestoUp = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "up"),
+		"Whether the last scrape of the presto cluster succeeded.",
+		[]string{"cluster", "hostname"}, nil,
+	)
+
 	runningQueries = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "running_queries"),
 		"Running requests of the presto cluster.",
-		[]string{"hostname"}, nil,
+		[]string{"cluster", "hostname"}, nil,
 	)
 	blockedQueries = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "blocked_queries"),
 		"Blocked queries of the presto cluster.",
-		[]string{"hostname"}, nil,
+		[]string{"cluster", "hostname"}, nil,
 	)
 	queuedQueries = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "queued_queries"),
 		"Queued queries of the presto cluster.",
-		[]string{"hostname"}, nil,
+		[]string{"cluster", "hostname"}, nil,
 	)
 	activeWorkers = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "active_workers"),
 		"Active workers of the presto cluster.",
-		[]string{"hostname"}, nil,
+		[]string{"cluster", "hostname"}, nil,
 	)
 	runningDrivers = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "running_drivers"),
 		"Running drivers of the presto cluster.",
-		[]string{"hostname"}, nil,
+		[]string{"cluster", "hostname"}, nil,
 	)
 	reservedMemory = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "reserved_memory"),
 		"Reserved memory of the presto cluster.",
-		[]string{"hostname"}, nil,
+		[]string{"cluster", "hostname"}, nil,
 	)
 	totalInputRows = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "total_input_rows"),
 		"Total input rows of the presto cluster.",
-		[]string{"hostname"}, nil,
+		[]string{"cluster", "hostname"}, nil,
 	)
 	totalInputBytes = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "total_input_bytes"),
 		"Total input bytes of the presto cluster.",
-		[]string{"hostname"}, nil,
+		[]string{"cluster", "hostname"}, nil,
 	)
 	totalCpuTimeSecs = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "total_cpu_time_secs"),
 		"Total cpu time of the presto cluster.",
-		[]string{"hostname"}, nil,
+		[]string{"cluster", "hostname"}, nil,
 	)
 	uptime = prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, "", "uptime"),
 		"Total up time of the presto cluster.",
-		[]string{"hostname"}, nil,
+		[]string{"cluster", "hostname"}, nil,
 	)
 
-	querys = prometheus.NewDesc(
-		prometheus.BuildFQName(namespace, "", "querys"),
-		"Querys of the presto cluster.",
-		[]string{"hostname", "queryId", "state", "scheduled", "query", "queuedTime", "elapsedTime", "executionTime", "totalDrivers", "rawInputDataSize", "cumulativeUserMemory", "PeakUserMemoryReservation", "totalCpuTime", "totalScheduledTime"}, nil,
-	)
+	// Native (sparse) histograms for per-query queued-time and peak-memory,
+	// one series per cluster. These are kept as long-lived collectors
+	// rather than const metrics so Prometheus can maintain
+	// exponentially-bucketed distributions across scrapes. Queries are
+	// deduplicated by queryId via QueryLog.ObserveLatency (see
+	// querylog.go), since /v1/query keeps reporting a query with the same
+	// values long after it completes.
+	queryQueuedTimeSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:                   namespace,
+		Name:                        "query_queued_time_seconds",
+		Help:                        "Queued time of completed queries.",
+		NativeHistogramBucketFactor: 1.1,
+	}, []string{"cluster"})
+	queryPeakUserMemoryReservationBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:                   namespace,
+		Name:                        "query_peak_user_memory_reservation_bytes",
+		Help:                        "Peak user memory reservation of completed queries.",
+		NativeHistogramBucketFactor: 1.1,
+	}, []string{"cluster"})
 )
 
 // Describe implements the prometheus.Collector interface.
-func (e Exporter) Describe(ch chan<- *prometheus.Desc) {
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- prestoUp
 	ch <- runningQueries
 	ch <- blockedQueries
 	ch <- queuedQueries
@@ -156,28 +191,95 @@ func (e Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- totalInputBytes
 	ch <- totalCpuTimeSecs
 	ch <- uptime
-	ch <- querys
 }
 
 func main() {
 	var (
 		listenAddress = kingpin.Flag("web.listen-address", "Address on which to expose metrics and web interface.").Default(":9483").String()
 		metricsPath   = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
-		opts          = Exporter{}
+		webURLs       = kingpin.Flag("web.url", "Presto coordinator address. Repeatable to federate multiple clusters.").Default("http://localhost:8080").Strings()
+		configFile    = kingpin.Flag("config.file", "YAML file listing Presto clusters to scrape. Overrides --web.url.").String()
+		scrapeTimeout = kingpin.Flag("presto.timeout", "Timeout for HTTP requests to each Presto coordinator.").Default("10s").Duration()
+		maxParallel   = kingpin.Flag("scrape.max-parallel", "Maximum number of clusters to scrape in parallel, and of workers per cluster to scrape in parallel for JMX metrics.").Default("5").Int()
+
+		prestoUser               = kingpin.Flag("presto.user", "Value of the X-Presto-User header and, if --presto.password-file is set, the basic auth username.").Default("presto_exporter").String()
+		prestoPasswordFile       = kingpin.Flag("presto.password-file", "File containing the HTTP basic auth password.").String()
+		prestoBearerTokenFile    = kingpin.Flag("presto.bearer-token-file", "File containing a bearer (JWT) token. Takes precedence over --presto.password-file.").String()
+		prestoCAFile             = kingpin.Flag("presto.ca-file", "PEM file with CA certificates to trust in addition to the system pool.").String()
+		prestoCertFile           = kingpin.Flag("presto.cert-file", "PEM file with the client certificate for mTLS. Requires --presto.key-file.").String()
+		prestoKeyFile            = kingpin.Flag("presto.key-file", "PEM file with the client private key for mTLS. Requires --presto.cert-file.").String()
+		prestoInsecureSkipVerify = kingpin.Flag("presto.insecure-skip-verify", "Skip TLS certificate verification for Presto coordinators.").Default("false").Bool()
+
+		jmxMbeansFile = kingpin.Flag("presto.jmx.mbeans-file", "YAML file of extra JMX mbean/attribute selectors to scrape from each worker, in addition to the built-in defaults.").String()
 	)
-	kingpin.Flag("web.url", "Presto cluster address.").Default("http://localhost:8080").StringVar(&opts.uri)
 
-	log.AddFlags(kingpin.CommandLine)
 	kingpin.Version(version.Print("presto_exporter"))
 	kingpin.HelpFlag.Short('h')
 	kingpin.Parse()
 
-	log.Infoln("Starting presto_exporter", version.Info())
-	log.Infoln("Build context", version.BuildContext())
+	var clusters []ClusterConfig
+	if *configFile != "" {
+		cfg, err := loadConfigFile(*configFile)
+		if err != nil {
+			log.Fatalf("error loading %s: %s", *configFile, err)
+		}
+		clusters = cfg.Clusters
+	} else {
+		clusters = clustersFromURLs(*webURLs)
+	}
+
+	log.Println("Starting presto_exporter", version.Info())
+	log.Println("Build context", version.BuildContext())
+
+	client, err := newHTTPClient(ClientConfig{
+		User:               *prestoUser,
+		PasswordFile:       *prestoPasswordFile,
+		BearerTokenFile:    *prestoBearerTokenFile,
+		CAFile:             *prestoCAFile,
+		CertFile:           *prestoCertFile,
+		KeyFile:            *prestoKeyFile,
+		InsecureSkipVerify: *prestoInsecureSkipVerify,
+		Timeout:            *scrapeTimeout,
+	})
+	if err != nil {
+		log.Fatalf("error configuring presto HTTP client: %s", err)
+	}
+
+	jmxSelectors := defaultJMXSelectors
+	if *jmxMbeansFile != "" {
+		extra, err := loadJMXSelectors(*jmxMbeansFile)
+		if err != nil {
+			log.Fatalf("error loading %s: %s", *jmxMbeansFile, err)
+		}
+		jmxSelectors = append(jmxSelectors, extra...)
+	}
+
+	queryLog := NewQueryLog()
 
-	prometheus.MustRegister(&Exporter{uri: opts.uri})
+	prometheus.MustRegister(&Exporter{
+		clusters:    clusters,
+		client:      client,
+		maxParallel: *maxParallel,
+		queryLog:    queryLog,
+	})
+	prometheus.MustRegister(&JMXExporter{
+		clusters:    clusters,
+		client:      client,
+		maxParallel: *maxParallel,
+		selectors:   jmxSelectors,
+		descs:       buildJMXDescs(jmxSelectors),
+	})
+	prometheus.MustRegister(
+		queryQueuedTimeSeconds,
+		queryPeakUserMemoryReservationBytes,
+	)
+	registerExporterMetrics()
+	registerQueryLogMetrics()
 
-	http.Handle(*metricsPath, promhttp.Handler())
+	metricsHandler := promhttp.InstrumentHandlerDuration(exporterHTTPRequestDuration,
+		promhttp.InstrumentHandlerCounter(exporterHTTPRequestsTotal, promhttp.Handler()))
+	http.Handle(*metricsPath, metricsHandler)
+	http.Handle("/queries", queryLog)
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
 			<head><title>Presto Exporter</title></head>
@@ -188,113 +290,139 @@ func main() {
 			</html>`))
 	})
 
-	log.Infoln("Listening on", *listenAddress)
+	log.Println("Listening on", *listenAddress)
 	log.Fatal(http.ListenAndServe(*listenAddress, nil))
 }
 
-// Collect implements the prometheus.Collector interface.
+// Collect implements the prometheus.Collector interface. Clusters are
+// scraped concurrently, bounded by maxParallel, so a single slow or
+// unreachable coordinator cannot stall or blank out the others.
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	maxParallel := e.maxParallel
+	if maxParallel < 1 {
+		maxParallel = 1
+	}
+	sem := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+
+	for _, cluster := range e.clusters {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(c ClusterConfig) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			e.collectCluster(c, ch)
+		}(cluster)
+	}
+	wg.Wait()
+}
+
+// collectCluster scrapes a single Presto coordinator and emits its metrics
+// labeled with the cluster's name. Any HTTP failure reports the cluster as
+// down via prestoUp, counts against scrapeErrorsTotal for the failing
+// endpoint, and aborts only that cluster's scrape.
+func (e *Exporter) collectCluster(cluster ClusterConfig, ch chan<- prometheus.Metric) {
+	start := time.Now()
+	defer func() {
+		lastScrapeDuration.WithLabelValues(cluster.Name).Set(time.Since(start).Seconds())
+	}()
+
+	fail := func(endpoint string, err error) {
+		log.Printf("error scraping cluster %s %s endpoint: %s", cluster.Name, endpoint, err)
+		scrapeErrorsTotal.WithLabelValues(endpoint).Inc()
+		ch <- prometheus.MustNewConstMetric(prestoUp, prometheus.GaugeValue, 0, cluster.Name, hostname)
+	}
+
 	// cluster
-	clusterResp, err := http.Get(e.uri + "/v1/cluster")
+	clusterResp, err := e.client.Get(cluster.URL + "/v1/cluster")
 	if err != nil {
-		log.Errorf("%s", err)
+		fail("cluster", err)
 		return
 	}
 	if clusterResp.StatusCode != 200 {
-		log.Errorf("%s", err)
+		clusterResp.Body.Close()
+		fail("cluster", fmt.Errorf("unexpected status %s", clusterResp.Status))
 		return
 	}
 	defer clusterResp.Body.Close()
 
 	clusterBody, err := ioutil.ReadAll(clusterResp.Body)
 	if err != nil {
-		log.Errorf("%s", err)
+		fail("cluster", err)
 		return
 	}
 	var clusterExporter = ClusterExporter{}
 	err = json.Unmarshal(clusterBody, &clusterExporter)
 	if err != nil {
-		log.Errorf("%s", err)
+		fail("cluster", err)
 		return
 	}
 
 	// info
-	infoResp, err := http.Get(e.uri + "/v1/info")
+	infoResp, err := e.client.Get(cluster.URL + "/v1/info")
 	if err != nil {
-		log.Errorf("%s", err)
+		fail("info", err)
 		return
 	}
 	if infoResp.StatusCode != 200 {
-		log.Errorf("%s", err)
+		infoResp.Body.Close()
+		fail("info", fmt.Errorf("unexpected status %s", infoResp.Status))
 		return
 	}
 	defer infoResp.Body.Close()
 
 	infoBody, err := ioutil.ReadAll(infoResp.Body)
 	if err != nil {
-		log.Errorf("%s", err)
+		fail("info", err)
 		return
 	}
 	var infoExporter = InfoExporter{}
 	err = json.Unmarshal(infoBody, &infoExporter)
 	if err != nil {
-		log.Errorf("%s", err)
+		fail("info", err)
 		return
 	}
 
 	// query
-	queryResp, err := http.Get(e.uri + "/v1/query")
+	queryResp, err := e.client.Get(cluster.URL + "/v1/query")
 	if err != nil {
-		log.Errorf("%s", err)
+		fail("query", err)
 		return
 	}
 	if queryResp.StatusCode != 200 {
-		log.Errorf("%s", err)
+		queryResp.Body.Close()
+		fail("query", fmt.Errorf("unexpected status %s", queryResp.Status))
 		return
 	}
 	defer queryResp.Body.Close()
 
 	queryBody, err := ioutil.ReadAll(queryResp.Body)
 	if err != nil {
-		log.Errorf("%s", err)
+		fail("query", err)
 		return
 	}
 	var queryExporter = QueryExporter{}
 	err = json.Unmarshal(queryBody, &queryExporter.Querys)
 	if err != nil {
-		log.Errorf("%s", err)
+		fail("query", err)
 		return
 	}
 
-	ch <- prometheus.MustNewConstMetric(runningQueries, prometheus.GaugeValue, clusterExporter.RunningQueries, hostname)
-	ch <- prometheus.MustNewConstMetric(blockedQueries, prometheus.GaugeValue, clusterExporter.BlockedQueries, hostname)
-	ch <- prometheus.MustNewConstMetric(queuedQueries, prometheus.GaugeValue, clusterExporter.QueuedQueries, hostname)
-	ch <- prometheus.MustNewConstMetric(activeWorkers, prometheus.GaugeValue, clusterExporter.ActiveWorkers, hostname)
-	ch <- prometheus.MustNewConstMetric(runningDrivers, prometheus.GaugeValue, clusterExporter.RunningDrivers, hostname)
-	ch <- prometheus.MustNewConstMetric(reservedMemory, prometheus.GaugeValue, clusterExporter.ReservedMemory, hostname)
-	ch <- prometheus.MustNewConstMetric(totalInputRows, prometheus.GaugeValue, clusterExporter.TotalInputRows, hostname)
-	ch <- prometheus.MustNewConstMetric(totalInputBytes, prometheus.GaugeValue, clusterExporter.TotalInputBytes, hostname)
-	ch <- prometheus.MustNewConstMetric(totalCpuTimeSecs, prometheus.GaugeValue, clusterExporter.TotalCpuTimeSecs, hostname)
+	ch <- prometheus.MustNewConstMetric(prestoUp, prometheus.GaugeValue, 1, cluster.Name, hostname)
+	ch <- prometheus.MustNewConstMetric(runningQueries, prometheus.GaugeValue, clusterExporter.RunningQueries, cluster.Name, hostname)
+	ch <- prometheus.MustNewConstMetric(blockedQueries, prometheus.GaugeValue, clusterExporter.BlockedQueries, cluster.Name, hostname)
+	ch <- prometheus.MustNewConstMetric(queuedQueries, prometheus.GaugeValue, clusterExporter.QueuedQueries, cluster.Name, hostname)
+	ch <- prometheus.MustNewConstMetric(activeWorkers, prometheus.GaugeValue, clusterExporter.ActiveWorkers, cluster.Name, hostname)
+	ch <- prometheus.MustNewConstMetric(runningDrivers, prometheus.GaugeValue, clusterExporter.RunningDrivers, cluster.Name, hostname)
+	ch <- prometheus.MustNewConstMetric(reservedMemory, prometheus.GaugeValue, clusterExporter.ReservedMemory, cluster.Name, hostname)
+	ch <- prometheus.MustNewConstMetric(totalInputRows, prometheus.GaugeValue, clusterExporter.TotalInputRows, cluster.Name, hostname)
+	ch <- prometheus.MustNewConstMetric(totalInputBytes, prometheus.GaugeValue, clusterExporter.TotalInputBytes, cluster.Name, hostname)
+	ch <- prometheus.MustNewConstMetric(totalCpuTimeSecs, prometheus.GaugeValue, clusterExporter.TotalCpuTimeSecs, cluster.Name, hostname)
 	uptimeF, _ := strconv.ParseFloat(strings.TrimSuffix(infoExporter.Uptime, "d"), 32)
-	ch <- prometheus.MustNewConstMetric(uptime, prometheus.GaugeValue, uptimeF, hostname)
+	ch <- prometheus.MustNewConstMetric(uptime, prometheus.GaugeValue, uptimeF, cluster.Name, hostname)
 
 	for _, v := range queryExporter.Querys {
-		labels := []string{
-			hostname,
-			v.QueryId,
-			v.State,
-			strconv.FormatBool(v.Scheduled),
-			v.Query,
-			v.QueryStats.QueuedTime,
-			v.QueryStats.ElapsedTime,
-			v.QueryStats.ExecutionTime,
-			strconv.Itoa(v.QueryStats.TotalDrivers),
-			v.QueryStats.RawInputDataSize,
-			strconv.FormatFloat(v.QueryStats.CumulativeUserMemory, 'E', -1, 32),
-			v.QueryStats.PeakUserMemoryReservation,
-			v.QueryStats.TotalCpuTime,
-			v.QueryStats.TotalScheduledTime,
-		}
-		ch <- prometheus.MustNewConstMetric(querys, prometheus.GaugeValue, v.QueryStats.CumulativeUserMemory, labels...)
+		e.queryLog.ObserveLatency(cluster.Name, v)
+		e.queryLog.Observe(cluster.Name, v)
 	}
 }
@@ -0,0 +1,262 @@
+/**
+ * Copyright (C) 2018 Yahoo Japan Corporation
+ *
+ * Licensed under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ * <p/>
+ * http://www.apache.org/licenses/LICENSE-2.0
+ * <p/>
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxSeenQueryIds bounds the LRU of queryIds queryLog has already counted,
+// so a long-running exporter doesn't grow this set without bound.
+const maxSeenQueryIds = 10000
+
+// maxRecentQueries bounds the ring buffer backing the /queries endpoint.
+const maxRecentQueries = 200
+
+// completedQueryStates are the terminal Presto query states. Queries in any
+// other state (RUNNING, QUEUED, PLANNING, ...) are not yet final, so
+// QueryLog.Observe skips them until they reach one of these.
+var completedQueryStates = map[string]bool{
+	"FINISHED": true,
+	"FAILED":   true,
+	"CANCELED": true,
+}
+
+var (
+	queriesCompletedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "queries_completed_total",
+		Help:      "Total completed queries, by terminal state, user, source and resource group.",
+	}, []string{"cluster", "state", "user", "source", "resource_group"})
+
+	queryExecutionSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:                   namespace,
+		Name:                        "query_execution_seconds",
+		Help:                        "Execution time of completed queries.",
+		NativeHistogramBucketFactor: 1.1,
+	}, []string{"cluster"})
+
+	queryInputBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:                   namespace,
+		Name:                        "query_input_bytes",
+		Help:                        "Raw input data size of completed queries.",
+		NativeHistogramBucketFactor: 1.1,
+	}, []string{"cluster"})
+
+	slowestQuerySeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "slowest_query_seconds",
+		Help:      "Execution time of the slowest completed query seen so far, per cluster.",
+	}, []string{"cluster"})
+)
+
+// registerQueryLogMetrics registers the query-log subsystem's metrics,
+// separately from the per-cluster Exporter collector.
+func registerQueryLogMetrics() {
+	prometheus.MustRegister(
+		queriesCompletedTotal,
+		queryExecutionSeconds,
+		queryInputBytes,
+		slowestQuerySeconds,
+	)
+}
+
+// recentQuery is the low-cardinality summary of a completed query kept for
+// the /queries JSON endpoint, since the full Query struct's free-form "query"
+// text is unbounded cardinality and unsuitable for a Prometheus label.
+type recentQuery struct {
+	Cluster       string  `json:"cluster"`
+	QueryId       string  `json:"queryId"`
+	State         string  `json:"state"`
+	User          string  `json:"user"`
+	Source        string  `json:"source"`
+	ResourceGroup string  `json:"resourceGroup"`
+	ExecutionSecs float64 `json:"executionSeconds"`
+	InputBytes    float64 `json:"inputBytes"`
+}
+
+// QueryLog aggregates completed Presto queries into low-cardinality
+// metrics, replacing the one-series-per-query "querys" Desc it supersedes.
+// It deduplicates queryIds across scrapes with an LRU so a query is only
+// counted once even though /v1/query keeps reporting it until it ages out
+// of the coordinator's own history, and keeps a small ring buffer of recent
+// completions for human inspection via ServeHTTP.
+type QueryLog struct {
+	mu sync.Mutex
+
+	seen    map[string]*list.Element
+	seenLRU *list.List
+	recent  []recentQuery
+	slowest map[string]float64
+
+	// seenLatency dedupes ObserveLatency independently of seen/seenLRU
+	// above, so Observe marking a completed queryId as seen does not stop
+	// ObserveLatency from recording its queued-time/peak-memory once.
+	seenLatency    map[string]*list.Element
+	seenLatencyLRU *list.List
+}
+
+// NewQueryLog returns an empty QueryLog.
+func NewQueryLog() *QueryLog {
+	return &QueryLog{
+		seen:           make(map[string]*list.Element),
+		seenLRU:        list.New(),
+		slowest:        make(map[string]float64),
+		seenLatency:    make(map[string]*list.Element),
+		seenLatencyLRU: list.New(),
+	}
+}
+
+// Observe records a query seen on a cluster scrape. Only queries in a
+// terminal state are counted, and each queryId is counted at most once.
+func (q *QueryLog) Observe(cluster string, query Query) {
+	if !completedQueryStates[query.State] {
+		return
+	}
+
+	q.mu.Lock()
+	alreadySeen := q.markSeen(query.QueryId)
+	q.mu.Unlock()
+	if alreadySeen {
+		return
+	}
+
+	resourceGroup := strings.Join(query.ResourceGroupId, ".")
+	executionSecs := parsePrestoDuration(query.QueryStats.ExecutionTime)
+	inputBytes := parsePrestoDataSize(query.QueryStats.RawInputDataSize)
+
+	queriesCompletedTotal.WithLabelValues(cluster, query.State, query.Session.User, query.Session.Source, resourceGroup).Inc()
+	queryExecutionSeconds.WithLabelValues(cluster).Observe(executionSecs)
+	queryInputBytes.WithLabelValues(cluster).Observe(inputBytes)
+	q.updateSlowest(cluster, executionSecs)
+
+	q.recordRecent(recentQuery{
+		Cluster:       cluster,
+		QueryId:       query.QueryId,
+		State:         query.State,
+		User:          query.Session.User,
+		Source:        query.Session.Source,
+		ResourceGroup: resourceGroup,
+		ExecutionSecs: executionSecs,
+		InputBytes:    inputBytes,
+	})
+}
+
+// ObserveLatency records a query's final queued-time and peak-memory
+// exactly once per queryId, using its own "seen" set (separate from
+// Observe's) since the two are independent concerns. Like Observe, it is
+// gated on completedQueryStates: a query's queued-time keeps growing and
+// its peak memory keeps rising while it is still QUEUED or RUNNING, so
+// recording on first sight (rather than on completion) would lock in a
+// partial, near-zero value instead of the query's real final one.
+func (q *QueryLog) ObserveLatency(cluster string, query Query) {
+	if !completedQueryStates[query.State] {
+		return
+	}
+
+	q.mu.Lock()
+	alreadySeen := q.markSeenLatency(query.QueryId)
+	q.mu.Unlock()
+	if alreadySeen {
+		return
+	}
+
+	queryQueuedTimeSeconds.WithLabelValues(cluster).Observe(parsePrestoDuration(query.QueryStats.QueuedTime))
+	queryPeakUserMemoryReservationBytes.WithLabelValues(cluster).Observe(parsePrestoDataSize(query.QueryStats.PeakUserMemoryReservation))
+}
+
+// markSeen reports whether queryId has already been observed, recording it
+// as seen (and evicting the oldest entry once maxSeenQueryIds is exceeded)
+// if not. Callers must hold q.mu.
+func (q *QueryLog) markSeen(queryId string) bool {
+	return markSeenIn(q.seen, q.seenLRU, queryId)
+}
+
+// markSeenLatency is markSeen's counterpart for ObserveLatency's dedup set.
+// Callers must hold q.mu.
+func (q *QueryLog) markSeenLatency(queryId string) bool {
+	return markSeenIn(q.seenLatency, q.seenLatencyLRU, queryId)
+}
+
+// markSeenIn reports whether id is already present in seen/lru, inserting
+// it and evicting the oldest entry once maxSeenQueryIds is exceeded if not.
+func markSeenIn(seen map[string]*list.Element, lru *list.List, id string) bool {
+	if elem, ok := seen[id]; ok {
+		lru.MoveToFront(elem)
+		return true
+	}
+
+	elem := lru.PushFront(id)
+	seen[id] = elem
+
+	if lru.Len() > maxSeenQueryIds {
+		oldest := lru.Back()
+		lru.Remove(oldest)
+		delete(seen, oldest.Value.(string))
+	}
+
+	return false
+}
+
+// updateSlowest raises presto_cluster_slowest_query_seconds for cluster if
+// executionSecs is a new high-water mark.
+func (q *QueryLog) updateSlowest(cluster string, executionSecs float64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if executionSecs > q.slowest[cluster] {
+		q.slowest[cluster] = executionSecs
+		slowestQuerySeconds.WithLabelValues(cluster).Set(executionSecs)
+	}
+}
+
+// recordRecent appends rq to the ring buffer backing ServeHTTP, discarding
+// the oldest entry once maxRecentQueries is exceeded.
+func (q *QueryLog) recordRecent(rq recentQuery) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.recent = append(q.recent, rq)
+	if len(q.recent) > maxRecentQueries {
+		q.recent = q.recent[len(q.recent)-maxRecentQueries:]
+	}
+}
+
+// Entries returns the most recently completed queries, newest last.
+func (q *QueryLog) Entries() []recentQuery {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entries := make([]recentQuery, len(q.recent))
+	copy(entries, q.recent)
+	return entries
+}
+
+// ServeHTTP implements http.Handler, serving the recent completed queries as
+// JSON for human inspection. It is registered at /queries.
+func (q *QueryLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(q.Entries()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
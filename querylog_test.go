@@ -0,0 +1,125 @@
+package main
+
+import (
+	"container/list"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func finishedQuery(id string) Query {
+	q := Query{QueryId: id, State: "FINISHED"}
+	q.Session.User = "alice"
+	q.Session.Source = "presto-cli"
+	q.ResourceGroupId = []string{"global", "adhoc"}
+	q.QueryStats.ExecutionTime = "1.00s"
+	q.QueryStats.RawInputDataSize = "1.00MB"
+	q.QueryStats.QueuedTime = "10.00ms"
+	q.QueryStats.PeakUserMemoryReservation = "10.00MB"
+	return q
+}
+
+func TestQueryLogObserveDedupesByQueryId(t *testing.T) {
+	ql := NewQueryLog()
+	cluster := "test-observe-dedup"
+	q := finishedQuery("q1")
+
+	ql.Observe(cluster, q)
+	ql.Observe(cluster, q)
+
+	if got := testutil.ToFloat64(queriesCompletedTotal.WithLabelValues(cluster, "FINISHED", "alice", "presto-cli", "global.adhoc")); got != 1 {
+		t.Errorf("queriesCompletedTotal = %v, want 1", got)
+	}
+	if entries := ql.Entries(); len(entries) != 1 {
+		t.Errorf("len(Entries()) = %d, want 1", len(entries))
+	}
+}
+
+func TestQueryLogObserveSkipsNonTerminalStates(t *testing.T) {
+	ql := NewQueryLog()
+	cluster := "test-observe-running"
+	q := finishedQuery("q2")
+	q.State = "RUNNING"
+
+	ql.Observe(cluster, q)
+
+	if got := testutil.ToFloat64(queriesCompletedTotal.WithLabelValues(cluster, "RUNNING", "alice", "presto-cli", "global.adhoc")); got != 0 {
+		t.Errorf("queriesCompletedTotal = %v, want 0", got)
+	}
+	if entries := ql.Entries(); len(entries) != 0 {
+		t.Errorf("len(Entries()) = %d, want 0", len(entries))
+	}
+}
+
+func TestQueryLogObserveLatencyDedupesFinalValueOnly(t *testing.T) {
+	ql := NewQueryLog()
+	cluster := "test-observe-latency"
+	q := finishedQuery("q3")
+
+	before := testutil.CollectAndCount(queryQueuedTimeSeconds)
+	ql.ObserveLatency(cluster, q)
+	ql.ObserveLatency(cluster, q) // same queryId again: must not double-count
+	after := testutil.CollectAndCount(queryQueuedTimeSeconds)
+
+	if after-before != 1 {
+		t.Errorf("queryQueuedTimeSeconds series delta = %d, want 1", after-before)
+	}
+
+	m := &dto.Metric{}
+	if err := queryQueuedTimeSeconds.WithLabelValues(cluster).(prometheus.Metric).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GetHistogram().GetSampleCount(); got != 1 {
+		t.Errorf("queryQueuedTimeSeconds sample count = %d, want 1", got)
+	}
+}
+
+func TestQueryLogObserveLatencySkipsNonTerminalStates(t *testing.T) {
+	ql := NewQueryLog()
+	cluster := "test-observe-latency-running"
+	q := finishedQuery("q4")
+	q.State = "RUNNING"
+	q.QueryStats.QueuedTime = "1.00ms"
+	q.QueryStats.PeakUserMemoryReservation = "1.00MB"
+
+	before := testutil.CollectAndCount(queryQueuedTimeSeconds)
+	ql.ObserveLatency(cluster, q) // still RUNNING: must not record a partial value
+	after := testutil.CollectAndCount(queryQueuedTimeSeconds)
+
+	if after != before {
+		t.Errorf("queryQueuedTimeSeconds series delta = %d, want 0 while query is RUNNING", after-before)
+	}
+
+	q.State = "FINISHED"
+	q.QueryStats.QueuedTime = "50.00ms"
+	q.QueryStats.PeakUserMemoryReservation = "20.00MB"
+	ql.ObserveLatency(cluster, q) // now FINISHED: must record the final value
+
+	afterFinished := testutil.CollectAndCount(queryQueuedTimeSeconds)
+	if afterFinished-before != 1 {
+		t.Errorf("queryQueuedTimeSeconds series delta = %d, want 1 after completion", afterFinished-before)
+	}
+}
+
+func TestMarkSeenInEvictsOldestPastCap(t *testing.T) {
+	seen := make(map[string]*list.Element)
+	lru := list.New()
+
+	for i := 0; i < maxSeenQueryIds+1; i++ {
+		if alreadySeen := markSeenIn(seen, lru, string(rune(i))); alreadySeen {
+			t.Fatalf("id %d reported as already seen on first insert", i)
+		}
+	}
+
+	if lru.Len() != maxSeenQueryIds {
+		t.Errorf("lru.Len() = %d, want %d", lru.Len(), maxSeenQueryIds)
+	}
+	if _, ok := seen[string(rune(0))]; ok {
+		t.Errorf("oldest id was not evicted")
+	}
+	if _, ok := seen[string(rune(maxSeenQueryIds))]; !ok {
+		t.Errorf("most recently inserted id should still be present")
+	}
+}